@@ -0,0 +1,301 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// archiveFormatForContentType maps a POST request's Content-Type to the
+// archive format to extract, or "" if it isn't an archive upload.
+func archiveFormatForContentType(contentType string) string {
+	switch {
+	case strings.Contains(contentType, "application/x-tar"):
+		return "tar"
+	case strings.Contains(contentType, "application/gzip"), strings.Contains(contentType, "application/x-gzip"):
+		return "tar.gz"
+	case strings.Contains(contentType, "application/zip"):
+		return "zip"
+	}
+	return ""
+}
+
+// archiveFormatForGet returns the archive format a GET of a directory asked
+// for via ?format= or Accept, or "" for a normal directory listing.
+func archiveFormatForGet(req *http.Request) string {
+	switch req.URL.Query().Get("format") {
+	case "tar":
+		return "tar"
+	case "tar.gz":
+		return "tar.gz"
+	case "zip":
+		return "zip"
+	}
+	if strings.Contains(req.Header.Get("Accept"), "application/x-tar") {
+		return "tar"
+	}
+	return ""
+}
+
+// extractArchive unpacks an uploaded tar, tar.gz, or zip archive into root.
+func extractArchive(res http.ResponseWriter, req *http.Request, root string, format string) {
+	var err error
+	switch format {
+	case "tar":
+		err = extractTar(root, req.Body, false)
+	case "tar.gz":
+		err = extractTar(root, req.Body, true)
+	case "zip":
+		err = extractZip(root, req.Body)
+	}
+	if err != nil {
+		handleError(res, req, err, http.StatusBadRequest, "Error extracting archive")
+		return
+	}
+	res.WriteHeader(http.StatusOK)
+}
+
+func extractTar(root string, body io.Reader, gzipped bool) error {
+	r := body
+	if gzipped {
+		gz, err := gzip.NewReader(body)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		r = gz
+	}
+
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		if err := extractTarEntry(root, hdr, tr); err != nil {
+			return err
+		}
+	}
+}
+
+func extractTarEntry(root string, hdr *tar.Header, tr *tar.Reader) error {
+	target, err := safeJoin(root, hdr.Name)
+	if err != nil {
+		return err
+	}
+
+	switch hdr.Typeflag {
+	case tar.TypeDir:
+		return os.MkdirAll(target, os.FileMode(hdr.Mode))
+	case tar.TypeReg:
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return err
+		}
+		out, err := os.OpenFile(target, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, os.FileMode(hdr.Mode))
+		if err != nil {
+			return err
+		}
+		defer out.Close()
+		if _, err := io.Copy(out, tr); err != nil {
+			return err
+		}
+		return os.Chtimes(target, hdr.ModTime, hdr.ModTime)
+	default:
+		// symlinks, devices, etc. aren't supported; skip them
+		return nil
+	}
+}
+
+func extractZip(root string, body io.Reader) error {
+	tmp, err := ioutil.TempFile("", "shaas-upload-*.zip")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	size, err := io.Copy(tmp, body)
+	if err != nil {
+		return err
+	}
+
+	zr, err := zip.NewReader(tmp, size)
+	if err != nil {
+		return err
+	}
+
+	for _, zf := range zr.File {
+		if err := extractZipEntry(root, zf); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func extractZipEntry(root string, zf *zip.File) error {
+	target, err := safeJoin(root, zf.Name)
+	if err != nil {
+		return err
+	}
+
+	if zf.FileInfo().IsDir() {
+		return os.MkdirAll(target, zf.Mode())
+	}
+
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+
+	rc, err := zf.Open()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	out, err := os.OpenFile(target, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, zf.Mode())
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	if _, err := io.Copy(out, rc); err != nil {
+		return err
+	}
+	return os.Chtimes(target, zf.Modified, zf.Modified)
+}
+
+// safeJoin joins name onto root, rejecting any path that would escape root
+// (zip-slip / tar-slip).
+func safeJoin(root, name string) (string, error) {
+	cleaned := filepath.Clean(string(os.PathSeparator) + name)
+	target := filepath.Join(root, cleaned)
+
+	rootClean := filepath.Clean(root)
+	if target != rootClean && !strings.HasPrefix(target, rootClean+string(os.PathSeparator)) {
+		return "", fmt.Errorf("illegal file path in archive: %q", name)
+	}
+	return target, nil
+}
+
+// serveArchive streams a live-generated tar, tar.gz or zip of root's contents.
+func serveArchive(res http.ResponseWriter, req *http.Request, root string, format string) {
+	base := filepath.Base(root)
+
+	var err error
+	switch format {
+	case "tar":
+		res.Header().Set("Content-Type", "application/x-tar")
+		res.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", base+".tar"))
+		tw := tar.NewWriter(res)
+		err = writeTarDir(tw, root)
+		if closeErr := tw.Close(); err == nil {
+			err = closeErr
+		}
+	case "tar.gz":
+		res.Header().Set("Content-Type", "application/gzip")
+		res.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", base+".tar.gz"))
+		gw := gzip.NewWriter(res)
+		tw := tar.NewWriter(gw)
+		err = writeTarDir(tw, root)
+		if closeErr := tw.Close(); err == nil {
+			err = closeErr
+		}
+		if closeErr := gw.Close(); err == nil {
+			err = closeErr
+		}
+	case "zip":
+		res.Header().Set("Content-Type", "application/zip")
+		res.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", base+".zip"))
+		zw := zip.NewWriter(res)
+		err = writeZipDir(zw, root)
+		if closeErr := zw.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	if err != nil {
+		log.Printf("method=%s path=%q message=%q", req.Method, req.URL.Path, err)
+	}
+}
+
+func writeTarDir(tw *tar.Writer, root string) error {
+	return filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil || rel == "." {
+			return err
+		}
+
+		hdr, err := tar.FileInfoHeader(info, "")
+		if err != nil {
+			return err
+		}
+		hdr.Name = rel
+		if info.IsDir() {
+			hdr.Name += "/"
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = io.Copy(tw, f)
+		return err
+	})
+}
+
+func writeZipDir(zw *zip.Writer, root string) error {
+	return filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil || rel == "." {
+			return err
+		}
+
+		hdr, err := zip.FileInfoHeader(info)
+		if err != nil {
+			return err
+		}
+		hdr.Name = rel
+		hdr.Method = zip.Deflate
+
+		if info.IsDir() {
+			hdr.Name += "/"
+			_, err := zw.CreateHeader(hdr)
+			return err
+		}
+
+		w, err := zw.CreateHeader(hdr)
+		if err != nil {
+			return err
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = io.Copy(w, f)
+		return err
+	})
+}
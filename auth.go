@@ -0,0 +1,370 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+var authUser, authPassword string
+var requireBasicAuth bool
+
+var bearerTokensEnv map[string]struct{}
+var bearerTokensFile string
+var netrcFilePath string
+var aclFilePath string
+
+var bearerFileCache lineFileCache
+var netrcCache credentialFileCache
+var aclCache aclFileCache
+
+func init() {
+	basicAuth := os.Getenv("BASIC_AUTH")
+	if basicAuth != "" {
+		requireBasicAuth = true
+		bits := strings.SplitN(basicAuth, ":", 2)
+		authUser = bits[0]
+		if len(bits) == 2 {
+			authPassword = bits[1]
+		}
+	}
+
+	if tokens := os.Getenv("BEARER_TOKENS"); tokens != "" {
+		bearerTokensEnv = map[string]struct{}{}
+		for _, token := range strings.Split(tokens, ",") {
+			if token = strings.TrimSpace(token); token != "" {
+				bearerTokensEnv[token] = struct{}{}
+			}
+		}
+	}
+	bearerTokensFile = os.Getenv("BEARER_TOKENS_FILE")
+	netrcFilePath = os.Getenv("NETRC_FILE")
+	aclFilePath = os.Getenv("ACL_FILE")
+}
+
+func isWebSocketRequest(req *http.Request) bool {
+	return strings.HasPrefix(req.Header.Get("Origin"), "ws://") // TODO: how to detect? scheme is null
+}
+
+type contextKey int
+
+const userContextKey contextKey = iota
+
+// withUser attaches the authenticated user (empty string if auth is
+// disabled) to req's context, so handlers that exec something other than
+// req.URL.Path itself - like createJob's "path" field - can re-run
+// authorizeACL against their own resolved target.
+func withUser(req *http.Request, user string) *http.Request {
+	return req.WithContext(context.WithValue(req.Context(), userContextKey, user))
+}
+
+// requestUser returns the user attached by withUser, or "" if none was.
+func requestUser(req *http.Request) string {
+	user, _ := req.Context().Value(userContextKey).(string)
+	return user
+}
+
+// authRequired reports whether any authentication scheme is configured.
+// With nothing configured, shaas keeps its original wide-open behavior.
+func authRequired() bool {
+	return requireBasicAuth || bearerTokensEnv != nil || bearerTokensFile != "" || netrcFilePath != ""
+}
+
+// authenticate validates the request's Authorization header against
+// whichever schemes are configured (bearer token, BASIC_AUTH, or a .netrc
+// credentials file) and returns the authenticated user name.
+func authenticate(req *http.Request) (string, bool) {
+	if user, ok := authenticateBearer(req); ok {
+		return user, true
+	}
+	if user, pass, ok := req.BasicAuth(); ok {
+		return authenticateBasic(user, pass)
+	}
+	return "", false
+}
+
+func authenticateBearer(req *http.Request) (string, bool) {
+	const prefix = "Bearer "
+
+	header := req.Header.Get("Authorization")
+	if !strings.HasPrefix(header, prefix) {
+		return "", false
+	}
+
+	token := strings.TrimSpace(strings.TrimPrefix(header, prefix))
+	if token == "" {
+		return "", false
+	}
+
+	for candidate := range bearerTokensEnv {
+		if constantTimeEqual(token, candidate) {
+			return token, true
+		}
+	}
+	for _, candidate := range bearerFileCache.load(bearerTokensFile) {
+		if constantTimeEqual(token, candidate) {
+			return token, true
+		}
+	}
+	return "", false
+}
+
+func authenticateBasic(user, pass string) (string, bool) {
+	if requireBasicAuth && constantTimeEqual(user, authUser) && constantTimeEqual(pass, authPassword) {
+		return user, true
+	}
+	for _, cred := range netrcCache.load(netrcFilePath) {
+		if constantTimeEqual(user, cred.login) && constantTimeEqual(pass, cred.password) {
+			return user, true
+		}
+	}
+	return "", false
+}
+
+func constantTimeEqual(a, b string) bool {
+	return len(a) == len(b) && subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
+
+// lineFileCache hot-reloads a newline-delimited file (e.g. bearer tokens),
+// re-reading it only when its mtime changes.
+type lineFileCache struct {
+	mu      sync.Mutex
+	path    string
+	modTime time.Time
+	lines   []string
+}
+
+func (c *lineFileCache) load(path string) []string {
+	if path == "" {
+		return nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	info, err := os.Stat(path)
+	if err != nil {
+		log.Printf("message=%q", err)
+		return c.lines
+	}
+	if c.path == path && info.ModTime().Equal(c.modTime) {
+		return c.lines
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		log.Printf("message=%q", err)
+		return c.lines
+	}
+
+	var lines []string
+	for _, line := range strings.Split(string(data), "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			lines = append(lines, line)
+		}
+	}
+
+	c.path = path
+	c.modTime = info.ModTime()
+	c.lines = lines
+	return c.lines
+}
+
+// netrcCredential is one machine/login/password triple parsed from a
+// .netrc-style file.
+type netrcCredential struct {
+	machine  string
+	login    string
+	password string
+}
+
+// credentialFileCache hot-reloads a .netrc-style credentials file.
+type credentialFileCache struct {
+	mu      sync.Mutex
+	path    string
+	modTime time.Time
+	creds   []netrcCredential
+}
+
+func (c *credentialFileCache) load(path string) []netrcCredential {
+	if path == "" {
+		return nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	info, err := os.Stat(path)
+	if err != nil {
+		log.Printf("message=%q", err)
+		return c.creds
+	}
+	if c.path == path && info.ModTime().Equal(c.modTime) {
+		return c.creds
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		log.Printf("message=%q", err)
+		return c.creds
+	}
+
+	c.path = path
+	c.modTime = info.ModTime()
+	c.creds = parseNetrc(string(data))
+	return c.creds
+}
+
+// parseNetrc understands the "machine"/"login"/"password"/"default"
+// keywords of the standard .netrc format. "macdef" entries aren't
+// supported and are ignored.
+func parseNetrc(data string) []netrcCredential {
+	fields := strings.Fields(data)
+
+	var creds []netrcCredential
+	var cur netrcCredential
+	have := false
+
+	flush := func() {
+		if have {
+			creds = append(creds, cur)
+		}
+		cur = netrcCredential{}
+		have = false
+	}
+
+	for i := 0; i < len(fields); i++ {
+		switch fields[i] {
+		case "machine":
+			flush()
+			if i+1 < len(fields) {
+				cur.machine = fields[i+1]
+				have = true
+				i++
+			}
+		case "default":
+			flush()
+			cur.machine = "default"
+			have = true
+		case "login":
+			if i+1 < len(fields) {
+				cur.login = fields[i+1]
+				i++
+			}
+		case "password":
+			if i+1 < len(fields) {
+				cur.password = fields[i+1]
+				i++
+			}
+		}
+	}
+	flush()
+
+	return creds
+}
+
+// aclRule maps a path prefix to the users and HTTP methods (GET/POST/WS)
+// allowed to reach it.
+type aclRule struct {
+	Prefix  string   `json:"prefix"`
+	Users   []string `json:"users"`
+	Methods []string `json:"methods"`
+}
+
+// aclFileCache hot-reloads a JSON file containing a list of aclRule.
+type aclFileCache struct {
+	mu      sync.Mutex
+	path    string
+	modTime time.Time
+	rules   []aclRule
+}
+
+func (c *aclFileCache) load(path string) []aclRule {
+	if path == "" {
+		return nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	info, err := os.Stat(path)
+	if err != nil {
+		log.Printf("message=%q", err)
+		return c.rules
+	}
+	if c.path == path && info.ModTime().Equal(c.modTime) {
+		return c.rules
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		log.Printf("message=%q", err)
+		return c.rules
+	}
+
+	var rules []aclRule
+	if err := json.Unmarshal(data, &rules); err != nil {
+		log.Printf("message=%q", err)
+		return c.rules
+	}
+
+	c.path = path
+	c.modTime = info.ModTime()
+	c.rules = rules
+	return c.rules
+}
+
+// authorizeACL reports whether user may perform method against path, per
+// the longest matching prefix rule in the configured ACL file. ACLs are
+// opt-in: with no ACL file configured, or no rule matching path, access
+// is allowed.
+func authorizeACL(path, method, user string) bool {
+	rules := aclCache.load(aclFilePath)
+	if rules == nil {
+		return true
+	}
+
+	var best *aclRule
+	for i := range rules {
+		rule := &rules[i]
+		if strings.HasPrefix(path, rule.Prefix) && (best == nil || len(rule.Prefix) > len(best.Prefix)) {
+			best = rule
+		}
+	}
+	if best == nil {
+		return true
+	}
+
+	return methodAllowed(best.Methods, method) && userAllowed(best.Users, user)
+}
+
+func methodAllowed(methods []string, method string) bool {
+	if len(methods) == 0 {
+		return true
+	}
+	for _, m := range methods {
+		if strings.EqualFold(m, method) {
+			return true
+		}
+	}
+	return false
+}
+
+func userAllowed(users []string, user string) bool {
+	if len(users) == 0 {
+		return true
+	}
+	for _, u := range users {
+		if u == "*" || u == user {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// isCGIRequest reports whether the client asked for CGI-style response
+// parsing via ?cgi=1 or an Accept: application/cgi header.
+func isCGIRequest(req *http.Request) bool {
+	return req.URL.Query().Get("cgi") == "1" || strings.Contains(req.Header.Get("Accept"), "application/cgi")
+}
+
+// handleCGI runs path as a CGI script: its stdout is expected to start with
+// "Header: value" lines up to a blank line, which are copied onto res,
+// before the remaining bytes are streamed through as the response body.
+func handleCGI(res http.ResponseWriter, req *http.Request, path *os.File, pathInfo os.FileInfo) {
+	cmd, err := newCmdForPath(path, pathInfo)
+	if err != nil {
+		handleError(res, req, err, http.StatusBadRequest, "Invalid file type for CGI. Only directories and regular executable file are supported")
+		return
+	}
+	cmd.Env = append(os.Environ(), cgiEnv(req)...)
+
+	sandboxCfg := loadSandboxConfig()
+	if cmd, err = prepareSandbox(cmd, sandboxCfg); err != nil {
+		handleError(res, req, err, http.StatusInternalServerError, "Error applying sandbox")
+		return
+	}
+
+	cmd.Stdin = req.Body
+	cmd.Stderr = os.Stderr // keep stdout clean for header parsing
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		handleError(res, req, err, http.StatusInternalServerError, "Error creating CGI output pipe")
+		return
+	}
+
+	if err := cmd.Start(); err != nil {
+		handleError(res, req, err, http.StatusInternalServerError, "Error starting CGI process")
+		return
+	}
+	cancel := armTimeout(cmd, sandboxCfg.timeout)
+
+	if err := writeCGIResponse(res, stdout); err != nil {
+		log.Printf("method=%s path=%q message=%q", req.Method, req.URL.Path, err)
+	}
+
+	err = cmd.Wait()
+	cancel()
+	if err != nil {
+		log.Printf("method=%s path=%q message=%q", req.Method, req.URL.Path, err)
+	}
+}
+
+// writeCGIResponse reads leading "Header: value" lines from stdout up to a
+// blank line, applying Status/Content-Type/Location/Set-Cookie (and any
+// other header) onto res, then copies the rest of stdout as the body.
+func writeCGIResponse(res http.ResponseWriter, stdout io.Reader) error {
+	reader := bufio.NewReader(stdout)
+	status := http.StatusOK
+
+	for {
+		line, err := reader.ReadString('\n')
+		trimmed := strings.TrimRight(line, "\r\n")
+
+		if trimmed == "" {
+			break
+		}
+
+		key, value, ok := splitCGIHeader(trimmed)
+		if !ok {
+			// not a header line; nothing to do but treat it as the start of
+			// the body, since the CGI response didn't send a blank line
+			res.WriteHeader(status)
+			if _, writeErr := res.Write([]byte(trimmed + "\n")); writeErr != nil {
+				return writeErr
+			}
+			_, copyErr := io.Copy(res, reader)
+			return copyErr
+		}
+
+		switch strings.ToLower(key) {
+		case "status":
+			if fields := strings.Fields(value); len(fields) > 0 {
+				if code, parseErr := strconv.Atoi(fields[0]); parseErr == nil {
+					status = code
+				}
+			}
+		case "location":
+			res.Header().Set("Location", value)
+			if status == http.StatusOK {
+				status = http.StatusFound
+			}
+		case "set-cookie":
+			res.Header().Add("Set-Cookie", value)
+		default:
+			res.Header().Set(http.CanonicalHeaderKey(key), value)
+		}
+
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+	}
+
+	res.WriteHeader(status)
+	_, err := io.Copy(res, reader)
+	return err
+}
+
+// splitCGIHeader splits a "Key: value" line. ok is false if line doesn't
+// look like a header at all.
+func splitCGIHeader(line string) (key, value string, ok bool) {
+	idx := strings.Index(line, ":")
+	if idx < 0 {
+		return "", "", false
+	}
+	return line[:idx], strings.TrimSpace(line[idx+1:]), true
+}
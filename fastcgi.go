@@ -0,0 +1,208 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// fastCGIBackend is a php-fpm-style FastCGI responder to front instead of
+// forking a process per request, e.g. "tcp://127.0.0.1:9000" or
+// "unix:/var/run/php-fpm.sock".
+var fastCGIBackend = os.Getenv("FASTCGI_BACKEND")
+
+// isFastCGIRequest reports whether the client asked to be proxied to the
+// configured FastCGI backend via ?fcgi=1 or an Accept: application/fcgi
+// header.
+func isFastCGIRequest(req *http.Request) bool {
+	return fastCGIBackend != "" &&
+		(req.URL.Query().Get("fcgi") == "1" || strings.Contains(req.Header.Get("Accept"), "application/fcgi"))
+}
+
+// FastCGI record types and the Responder role, per the FastCGI 1.0 spec.
+const (
+	fcgiVersion1 = 1
+
+	fcgiTypeBeginRequest = 1
+	fcgiTypeParams       = 4
+	fcgiTypeStdin        = 5
+	fcgiTypeStdout       = 6
+	fcgiTypeStderr       = 7
+	fcgiTypeEndRequest   = 3
+
+	fcgiRoleResponder = 1
+
+	fcgiMaxRecordContent = 65535
+	fcgiRequestID        = 1
+)
+
+func dialFastCGI(backend string) (net.Conn, error) {
+	switch {
+	case strings.HasPrefix(backend, "unix:"):
+		return net.Dial("unix", strings.TrimPrefix(backend, "unix:"))
+	case strings.HasPrefix(backend, "tcp://"):
+		return net.Dial("tcp", strings.TrimPrefix(backend, "tcp://"))
+	default:
+		return net.Dial("tcp", backend)
+	}
+}
+
+// handleFastCGI proxies req to backend as a FastCGI Responder request,
+// reusing cgiEnv for the FastCGI params and writeCGIResponse to translate
+// the backend's CGI-style stdout into an HTTP response.
+func handleFastCGI(res http.ResponseWriter, req *http.Request, backend string) {
+	conn, err := dialFastCGI(backend)
+	if err != nil {
+		handleError(res, req, err, http.StatusBadGateway, "Error connecting to FastCGI backend")
+		return
+	}
+	defer conn.Close()
+
+	body, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		handleError(res, req, err, http.StatusBadRequest, "Error reading request body")
+		return
+	}
+
+	params := map[string]string{}
+	for _, kv := range cgiEnv(req) {
+		if idx := strings.Index(kv, "="); idx >= 0 {
+			params[kv[:idx]] = kv[idx+1:]
+		}
+	}
+
+	if err := writeFastCGIRequest(conn, params, body); err != nil {
+		handleError(res, req, err, http.StatusBadGateway, "Error writing to FastCGI backend")
+		return
+	}
+
+	stdout, stderr, err := readFastCGIResponse(conn)
+	if len(stderr) > 0 {
+		log.Printf("method=%s path=%q message=%q", req.Method, req.URL.Path, string(stderr))
+	}
+	if err != nil {
+		handleError(res, req, err, http.StatusBadGateway, "Error reading from FastCGI backend")
+		return
+	}
+
+	if err := writeCGIResponse(res, bytes.NewReader(stdout)); err != nil {
+		log.Printf("method=%s path=%q message=%q", req.Method, req.URL.Path, err)
+	}
+}
+
+func writeFastCGIRequest(w io.Writer, params map[string]string, stdin []byte) error {
+	if err := writeFastCGIRecord(w, fcgiTypeBeginRequest, []byte{0, fcgiRoleResponder, 0, 0, 0, 0, 0, 0}); err != nil {
+		return err
+	}
+	if err := writeFastCGIStream(w, fcgiTypeParams, encodeFastCGIParams(params)); err != nil {
+		return err
+	}
+	return writeFastCGIStream(w, fcgiTypeStdin, stdin)
+}
+
+// writeFastCGIStream writes data as one or more records of recType,
+// followed by the zero-length record that terminates a FastCGI stream.
+func writeFastCGIStream(w io.Writer, recType byte, data []byte) error {
+	for len(data) > 0 {
+		n := len(data)
+		if n > fcgiMaxRecordContent {
+			n = fcgiMaxRecordContent
+		}
+		if err := writeFastCGIRecord(w, recType, data[:n]); err != nil {
+			return err
+		}
+		data = data[n:]
+	}
+	return writeFastCGIRecord(w, recType, nil)
+}
+
+func writeFastCGIRecord(w io.Writer, recType byte, content []byte) error {
+	padding := (8 - len(content)%8) % 8
+	header := [8]byte{
+		fcgiVersion1, recType,
+		byte(fcgiRequestID >> 8), byte(fcgiRequestID),
+		byte(len(content) >> 8), byte(len(content)),
+		byte(padding), 0,
+	}
+	if _, err := w.Write(header[:]); err != nil {
+		return err
+	}
+	if len(content) > 0 {
+		if _, err := w.Write(content); err != nil {
+			return err
+		}
+	}
+	if padding > 0 {
+		if _, err := w.Write(make([]byte, padding)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// encodeFastCGIParams encodes params as FastCGI name-value pairs (the
+// length-prefix uses 1 byte for lengths under 128, 4 bytes otherwise).
+func encodeFastCGIParams(params map[string]string) []byte {
+	var buf bytes.Buffer
+	for k, v := range params {
+		writeFastCGILen(&buf, len(k))
+		writeFastCGILen(&buf, len(v))
+		buf.WriteString(k)
+		buf.WriteString(v)
+	}
+	return buf.Bytes()
+}
+
+func writeFastCGILen(buf *bytes.Buffer, n int) {
+	if n < 128 {
+		buf.WriteByte(byte(n))
+		return
+	}
+	buf.WriteByte(byte(n>>24) | 0x80)
+	buf.WriteByte(byte(n >> 16))
+	buf.WriteByte(byte(n >> 8))
+	buf.WriteByte(byte(n))
+}
+
+// readFastCGIResponse reads records until FCGI_END_REQUEST, returning the
+// concatenated FCGI_STDOUT and FCGI_STDERR payloads.
+func readFastCGIResponse(r io.Reader) (stdout, stderr []byte, err error) {
+	var stdoutBuf, stderrBuf bytes.Buffer
+	var header [8]byte
+
+	for {
+		if _, err := io.ReadFull(r, header[:]); err != nil {
+			return stdoutBuf.Bytes(), stderrBuf.Bytes(), err
+		}
+
+		recType := header[1]
+		contentLen := int(header[4])<<8 | int(header[5])
+		paddingLen := int(header[6])
+
+		content := make([]byte, contentLen)
+		if contentLen > 0 {
+			if _, err := io.ReadFull(r, content); err != nil {
+				return stdoutBuf.Bytes(), stderrBuf.Bytes(), err
+			}
+		}
+		if paddingLen > 0 {
+			if _, err := io.CopyN(ioutil.Discard, r, int64(paddingLen)); err != nil {
+				return stdoutBuf.Bytes(), stderrBuf.Bytes(), err
+			}
+		}
+
+		switch recType {
+		case fcgiTypeStdout:
+			stdoutBuf.Write(content)
+		case fcgiTypeStderr:
+			stderrBuf.Write(content)
+		case fcgiTypeEndRequest:
+			return stdoutBuf.Bytes(), stderrBuf.Bytes(), nil
+		}
+	}
+}
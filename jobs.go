@@ -0,0 +1,467 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const jobsPrefix = "/>/jobs/"
+
+const defaultJobLogBytes = 1 << 20 // 1MiB per job
+
+// jobKillGrace is how long a job gets to exit after SIGTERM before DELETE
+// escalates to SIGKILL.
+const jobKillGrace = 5 * time.Second
+
+// defaultJobRetention is how long a finished job's status and log stay
+// queryable before it's reaped, so the job index and /tmp log files don't
+// grow without bound.
+const defaultJobRetention = 10 * time.Minute
+
+func jobLogBytes() int64 {
+	if v := os.Getenv("JOB_LOG_BYTES"); v != "" {
+		if n, err := strconv.ParseInt(v, 10, 64); err == nil && n > 0 {
+			return n
+		}
+	}
+	return defaultJobLogBytes
+}
+
+func jobRetention() time.Duration {
+	if v := os.Getenv("JOB_RETENTION_SECONDS"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 {
+			return time.Duration(n) * time.Second
+		}
+	}
+	return defaultJobRetention
+}
+
+type jobStatus string
+
+const (
+	jobRunning  jobStatus = "running"
+	jobExited   jobStatus = "exited"
+	jobSignaled jobStatus = "signaled"
+)
+
+// job tracks one asynchronously-running command started via POST />/jobs.
+type job struct {
+	id            string
+	path          string
+	cmd           *exec.Cmd
+	log           *ringLog
+	cancelTimeout func()
+
+	mu        sync.Mutex
+	status    jobStatus
+	exitCode  int
+	startedAt time.Time
+	endedAt   time.Time
+}
+
+func (j *job) snapshot() jobStatusResponse {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	resp := jobStatusResponse{
+		ID:        j.id,
+		Path:      j.path,
+		Status:    string(j.status),
+		StartedAt: j.startedAt,
+	}
+	if j.status != jobRunning {
+		exitCode := j.exitCode
+		endedAt := j.endedAt
+		resp.ExitCode = &exitCode
+		resp.EndedAt = &endedAt
+	}
+	return resp
+}
+
+// wait blocks until the job's command exits and records its final status.
+func (j *job) wait() {
+	err := j.cmd.Wait()
+	if j.cancelTimeout != nil {
+		j.cancelTimeout()
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	j.endedAt = time.Now()
+	exitErr, ok := err.(*exec.ExitError)
+	if !ok {
+		j.status = jobExited
+		if err != nil {
+			j.exitCode = -1
+		}
+		j.reap()
+		return
+	}
+
+	if ws, ok := exitErr.Sys().(syscall.WaitStatus); ok && ws.Signaled() {
+		j.status = jobSignaled
+		j.exitCode = -int(ws.Signal())
+		j.reap()
+		return
+	}
+	j.status = jobExited
+	j.exitCode = exitErr.ExitCode()
+	j.reap()
+}
+
+// reap schedules j's removal from the jobManager and the closing of its log
+// after jobRetention has elapsed, so a finished job stays queryable for a
+// while but doesn't live forever. Must be called with j.mu held.
+func (j *job) reap() {
+	time.AfterFunc(jobRetention(), func() {
+		jobs.remove(j.id)
+		j.log.Close()
+	})
+}
+
+func (j *job) signal(sig os.Signal) error {
+	j.mu.Lock()
+	proc := j.cmd.Process
+	running := j.status == jobRunning
+	j.mu.Unlock()
+
+	if !running || proc == nil {
+		return nil
+	}
+	return proc.Signal(sig)
+}
+
+// jobManager is the process-wide index of jobs, keyed by ID.
+type jobManager struct {
+	mu   sync.Mutex
+	jobs map[string]*job
+}
+
+var jobs = &jobManager{jobs: map[string]*job{}}
+
+func (m *jobManager) add(j *job) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.jobs[j.id] = j
+}
+
+func (m *jobManager) get(id string) (*job, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	j, ok := m.jobs[id]
+	return j, ok
+}
+
+func (m *jobManager) remove(id string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.jobs, id)
+}
+
+func (m *jobManager) list() []*job {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	list := make([]*job, 0, len(m.jobs))
+	for _, j := range m.jobs {
+		list = append(list, j)
+	}
+	return list
+}
+
+func newJobID() (string, error) {
+	buf := make([]byte, 8)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+// jobRequest is the POST />/jobs body: the path to run plus optional stdin
+// and environment overrides.
+type jobRequest struct {
+	Path  string            `json:"path"`
+	Stdin string            `json:"stdin,omitempty"`
+	Env   map[string]string `json:"env,omitempty"`
+}
+
+type jobCreatedResponse struct {
+	ID string `json:"id"`
+}
+
+type jobStatusResponse struct {
+	ID        string     `json:"id"`
+	Path      string     `json:"path"`
+	Status    string     `json:"status"`
+	ExitCode  *int       `json:"exit_code,omitempty"`
+	StartedAt time.Time  `json:"started_at"`
+	EndedAt   *time.Time `json:"ended_at,omitempty"`
+}
+
+func handleJobs(res http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case "POST":
+		createJob(res, req)
+	case "GET":
+		listJobs(res, req)
+	default:
+		http.Error(res, "Only GET and POST supported", http.StatusMethodNotAllowed)
+	}
+}
+
+func createJob(res http.ResponseWriter, req *http.Request) {
+	var jobReq jobRequest
+	if err := json.NewDecoder(req.Body).Decode(&jobReq); err != nil {
+		handleError(res, req, err, http.StatusBadRequest, "Error decoding job request")
+		return
+	}
+
+	// The ACL middleware in authorize() only checked req.URL.Path, which
+	// for this endpoint is the literal "/>/jobs" - re-check against the
+	// job's actual target so a caller can't use it to bypass a POST deny
+	// on jobReq.Path.
+	if !authorizeACL(jobReq.Path, "POST", requestUser(req)) {
+		handleError(res, req, fmt.Errorf("Forbidden"), http.StatusForbidden, "Not Authorized")
+		return
+	}
+
+	path, err := os.Open(jobReq.Path)
+	if err != nil {
+		handleError(res, req, err, http.StatusNotFound, "File not found")
+		return
+	}
+	defer path.Close()
+
+	pathInfo, err := path.Stat()
+	if err != nil {
+		handleError(res, req, err, http.StatusInternalServerError, "Error reading path info")
+		return
+	}
+
+	cmd, err := newCmdForPath(path, pathInfo)
+	if err != nil {
+		handleError(res, req, err, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	cmd.Env = os.Environ()
+	for k, v := range jobReq.Env {
+		cmd.Env = append(cmd.Env, k+"="+v)
+	}
+	cmd.Stdin = strings.NewReader(jobReq.Stdin)
+
+	sandboxCfg := loadSandboxConfig()
+	if cmd, err = prepareSandbox(cmd, sandboxCfg); err != nil {
+		handleError(res, req, err, http.StatusInternalServerError, "Error applying sandbox")
+		return
+	}
+
+	jobLog, err := newRingLog(jobLogBytes())
+	if err != nil {
+		handleError(res, req, err, http.StatusInternalServerError, "Error allocating job log")
+		return
+	}
+	cmd.Stdout = jobLog
+	cmd.Stderr = jobLog
+
+	id, err := newJobID()
+	if err != nil {
+		jobLog.Close()
+		handleError(res, req, err, http.StatusInternalServerError, "Error allocating job id")
+		return
+	}
+
+	j := &job{
+		id:        id,
+		path:      jobReq.Path,
+		cmd:       cmd,
+		log:       jobLog,
+		status:    jobRunning,
+		startedAt: time.Now(),
+	}
+
+	if err := cmd.Start(); err != nil {
+		jobLog.Close()
+		handleError(res, req, err, http.StatusInternalServerError, "Error starting job")
+		return
+	}
+	j.cancelTimeout = armTimeout(cmd, sandboxCfg.timeout)
+
+	jobs.add(j)
+	go j.wait()
+
+	res.Header().Set("Content-Type", "application/json")
+	res.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(res).Encode(jobCreatedResponse{ID: id})
+}
+
+func listJobs(res http.ResponseWriter, req *http.Request) {
+	list := jobs.list()
+	responses := make([]jobStatusResponse, 0, len(list))
+	for _, j := range list {
+		responses = append(responses, j.snapshot())
+	}
+
+	res.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(res).Encode(responses)
+}
+
+// handleJob serves GET/DELETE />/jobs/{id} and GET />/jobs/{id}/stdout.
+func handleJob(res http.ResponseWriter, req *http.Request) {
+	rest := strings.TrimPrefix(req.URL.Path, jobsPrefix)
+	parts := strings.SplitN(rest, "/", 2)
+
+	j, ok := jobs.get(parts[0])
+	if !ok {
+		handleError(res, req, nil, http.StatusNotFound, "Job not found")
+		return
+	}
+
+	if len(parts) == 2 && parts[1] == "stdout" {
+		if req.Method != "GET" {
+			http.Error(res, "Only GET supported", http.StatusMethodNotAllowed)
+			return
+		}
+		serveJobStdout(res, req, j)
+		return
+	}
+
+	switch req.Method {
+	case "GET":
+		res.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(res).Encode(j.snapshot())
+	case "DELETE":
+		terminateJob(res, req, j)
+	default:
+		http.Error(res, "Only GET and DELETE supported", http.StatusMethodNotAllowed)
+	}
+}
+
+// serveJobStdout serves the job's log, either as an ?offset= poll-friendly
+// tail (200, with the offset to resume from in X-Job-Log-Offset) or, if the
+// client sent a Range header, as a proper 206 Partial Content / 416 range
+// response.
+func serveJobStdout(res http.ResponseWriter, req *http.Request, j *job) {
+	if o := req.URL.Query().Get("offset"); o != "" {
+		offset, err := strconv.ParseInt(o, 10, 64)
+		if err != nil {
+			handleError(res, req, err, http.StatusBadRequest, "Invalid offset")
+			return
+		}
+		writeJobLogTail(res, req, j, offset)
+		return
+	}
+
+	if rangeHeader := req.Header.Get("Range"); rangeHeader != "" {
+		serveJobLogRange(res, req, j, rangeHeader)
+		return
+	}
+
+	writeJobLogTail(res, req, j, 0)
+}
+
+func writeJobLogTail(res http.ResponseWriter, req *http.Request, j *job, offset int64) {
+	data, next, err := j.log.readFrom(offset)
+	if err != nil {
+		handleError(res, req, err, http.StatusInternalServerError, "Error reading job log")
+		return
+	}
+
+	res.Header().Set("Content-Type", "application/octet-stream")
+	res.Header().Set("X-Job-Log-Offset", strconv.FormatInt(next, 10))
+	res.Write(data)
+}
+
+// serveJobLogRange serves a single "bytes=N-" or "bytes=N-M" range of the
+// job's log, honoring the end bound and replying 206/Content-Range (or 416
+// if the range can't be satisfied against the log as currently retained).
+func serveJobLogRange(res http.ResponseWriter, req *http.Request, j *job, rangeHeader string) {
+	start, end, hasEnd, ok := parseByteRange(rangeHeader)
+	if !ok {
+		handleError(res, req, fmt.Errorf("Invalid Range header %q", rangeHeader), http.StatusBadRequest, "Invalid Range header")
+		return
+	}
+
+	data, total, err := j.log.readFrom(start)
+	if err != nil {
+		handleError(res, req, err, http.StatusInternalServerError, "Error reading job log")
+		return
+	}
+
+	// readFrom clamps the start it actually served up to the oldest byte
+	// still retained (older bytes may have fallen off the ring); recover
+	// that clamped start so Content-Range describes what's really returned.
+	actualStart := total - int64(len(data))
+
+	if start >= total || (hasEnd && (end < start || end < actualStart)) {
+		res.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", total))
+		res.WriteHeader(http.StatusRequestedRangeNotSatisfiable)
+		return
+	}
+
+	if hasEnd {
+		if want := end - actualStart + 1; want < int64(len(data)) {
+			data = data[:want]
+		}
+	}
+
+	res.Header().Set("Content-Type", "application/octet-stream")
+	res.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", actualStart, actualStart+int64(len(data))-1, total))
+	res.WriteHeader(http.StatusPartialContent)
+	res.Write(data)
+}
+
+// parseByteRange parses a single-range "bytes=N-" or "bytes=N-M" Range
+// header into its start offset and optional inclusive end offset.
+// Multi-range requests ("bytes=0-10,20-30") aren't supported and report
+// ok=false.
+func parseByteRange(header string) (start, end int64, hasEnd, ok bool) {
+	const prefix = "bytes="
+	if !strings.HasPrefix(header, prefix) || strings.Contains(header, ",") {
+		return 0, 0, false, false
+	}
+
+	spec := strings.TrimPrefix(header, prefix)
+	dash := strings.IndexByte(spec, '-')
+	if dash < 0 {
+		return 0, 0, false, false
+	}
+
+	start, err := strconv.ParseInt(spec[:dash], 10, 64)
+	if err != nil || start < 0 {
+		return 0, 0, false, false
+	}
+	if spec[dash+1:] == "" {
+		return start, 0, false, true
+	}
+
+	end, err = strconv.ParseInt(spec[dash+1:], 10, 64)
+	if err != nil || end < 0 {
+		return 0, 0, false, false
+	}
+	return start, end, true, true
+}
+
+func terminateJob(res http.ResponseWriter, req *http.Request, j *job) {
+	if err := j.signal(syscall.SIGTERM); err != nil {
+		handleError(res, req, err, http.StatusInternalServerError, "Error signaling job")
+		return
+	}
+
+	go func() {
+		time.Sleep(jobKillGrace)
+		j.signal(syscall.SIGKILL)
+	}()
+
+	res.WriteHeader(http.StatusAccepted)
+}
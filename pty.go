@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+
+	"github.com/creack/pty"
+)
+
+// resizeMessage is a small control envelope clients can mix into an
+// interactive session's input stream to report terminal size changes.
+// It looks like: {"type":"resize","cols":80,"rows":24}
+type resizeMessage struct {
+	Type string `json:"type"`
+	Cols int    `json:"cols"`
+	Rows int    `json:"rows"`
+}
+
+var resizeSentinel = []byte(`{"type":"resize"`)
+
+// resizeFilterReader wraps an interactive session's input, intercepting
+// resizeMessage envelopes and applying them to ptmx instead of forwarding
+// them to the child process.
+type resizeFilterReader struct {
+	r    io.Reader
+	ptmx *os.File
+}
+
+func (rr *resizeFilterReader) Read(p []byte) (int, error) {
+	n, err := rr.r.Read(p)
+	if n > 0 {
+		if idx := bytes.Index(p[:n], resizeSentinel); idx >= 0 {
+			if end := bytes.IndexByte(p[idx:n], '}'); end >= 0 {
+				end += idx + 1
+
+				var msg resizeMessage
+				if jsonErr := json.Unmarshal(p[idx:end], &msg); jsonErr == nil && msg.Type == "resize" {
+					if sizeErr := pty.Setsize(rr.ptmx, &pty.Winsize{Rows: uint16(msg.Rows), Cols: uint16(msg.Cols)}); sizeErr != nil {
+						log.Printf("message=%q", sizeErr)
+					}
+				}
+
+				// drop the control envelope from the stream before it reaches the pty
+				copy(p[idx:], p[end:n])
+				n -= end - idx
+			}
+		}
+	}
+	return n, err
+}
+
+// runPTY starts cmd attached to a real pty, copying bytes between it and
+// the interactive session's in/out, honoring resizeMessage envelopes and
+// forwarding signals (Ctrl-C/Ctrl-D) transparently since the kernel's pty
+// line discipline turns them into SIGINT/EOF for the child's foreground
+// process group. The session tears down - and the child is reaped - as
+// soon as either side goes away first: the child exiting on its own (e.g.
+// the user types "exit") or the client disconnecting. onStart, if non-nil,
+// runs once the child has started and returns a cleanup func to run once
+// it has exited (used to arm/disarm a sandbox timeout).
+func runPTY(cmd *exec.Cmd, in io.Reader, out io.Writer, onStart func() func()) error {
+	ptmx, err := pty.Start(cmd)
+	if err != nil {
+		return err
+	}
+	defer ptmx.Close()
+
+	cleanup := func() {}
+	if onStart != nil {
+		cleanup = onStart()
+	}
+
+	copyDone := make(chan struct{})
+	go func() {
+		io.Copy(out, ptmx)
+		close(copyDone)
+	}()
+
+	waitDone := make(chan error, 1)
+	go func() {
+		waitDone <- cmd.Wait()
+	}()
+
+	inputDone := make(chan struct{})
+	go func() {
+		io.Copy(ptmx, &resizeFilterReader{r: in, ptmx: ptmx})
+		close(inputDone)
+	}()
+
+	// Tear down on whichever happens first: the child exiting on its own
+	// (e.g. the user typed "exit"), or the client disconnecting (in
+	// returns EOF/error). Either way, closing the master sends the child
+	// SIGHUP/EOF so it can't linger as a zombie past the copy that's still
+	// running.
+	select {
+	case err = <-waitDone:
+	case <-inputDone:
+		ptmx.Close()
+		err = <-waitDone
+	}
+
+	cleanup()
+	<-copyDone
+	return err
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"sync"
+)
+
+// ringLog is a bounded, on-disk log buffer: once it grows past capacity
+// the oldest bytes are dropped, so a long-running job can't fill the disk.
+type ringLog struct {
+	mu           sync.Mutex
+	file         *os.File
+	capacity     int64
+	totalWritten int64 // logical byte count ever written, including dropped bytes
+}
+
+func newRingLog(capacity int64) (*ringLog, error) {
+	f, err := ioutil.TempFile("", "shaas-job-log-")
+	if err != nil {
+		return nil, err
+	}
+	return &ringLog{file: f, capacity: capacity}, nil
+}
+
+func (r *ringLog) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	n, err := r.file.Write(p)
+	r.totalWritten += int64(n)
+	if err != nil {
+		return n, err
+	}
+
+	info, statErr := r.file.Stat()
+	if statErr != nil {
+		return n, statErr
+	}
+	if overflow := info.Size() - r.capacity; overflow > 0 {
+		if trimErr := r.trimLocked(overflow); trimErr != nil {
+			return n, trimErr
+		}
+	}
+	return n, nil
+}
+
+// trimLocked drops overflow bytes from the front of the log, keeping only
+// the most recent r.capacity bytes. Caller must hold r.mu.
+func (r *ringLog) trimLocked(overflow int64) error {
+	info, err := r.file.Stat()
+	if err != nil {
+		return err
+	}
+
+	keep := info.Size() - overflow
+	buf := make([]byte, keep)
+	if _, err := r.file.ReadAt(buf, overflow); err != nil && err != io.EOF {
+		return err
+	}
+	if err := r.file.Truncate(0); err != nil {
+		return err
+	}
+	if _, err := r.file.WriteAt(buf, 0); err != nil {
+		return err
+	}
+	_, err = r.file.Seek(0, io.SeekEnd)
+	return err
+}
+
+// dropped returns how many logical bytes have fallen off the front of the
+// ring. Caller must hold r.mu.
+func (r *ringLog) dropped() int64 {
+	info, err := r.file.Stat()
+	if err != nil {
+		return 0
+	}
+	if d := r.totalWritten - info.Size(); d > 0 {
+		return d
+	}
+	return 0
+}
+
+// readFrom returns the bytes logged at or after offset, clamping offset up
+// to the oldest byte still retained, plus the logical offset to resume
+// tailing from on the next call.
+func (r *ringLog) readFrom(offset int64) ([]byte, int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if dropped := r.dropped(); offset < dropped {
+		offset = dropped
+	}
+	start := offset - r.dropped()
+
+	info, err := r.file.Stat()
+	if err != nil {
+		return nil, r.totalWritten, err
+	}
+	if start >= info.Size() {
+		return []byte{}, r.totalWritten, nil
+	}
+
+	buf := make([]byte, info.Size()-start)
+	if _, err := r.file.ReadAt(buf, start); err != nil && err != io.EOF {
+		return nil, r.totalWritten, err
+	}
+	return buf, r.totalWritten, nil
+}
+
+func (r *ringLog) Close() error {
+	name := r.file.Name()
+	err := r.file.Close()
+	os.Remove(name)
+	return err
+}
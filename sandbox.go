@@ -0,0 +1,156 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// sandboxConfig is read from the environment by loadSandboxConfig each time
+// a command is prepared; unset values mean "don't apply this limit".
+type sandboxConfig struct {
+	chroot     string
+	uid        int
+	haveUID    bool
+	gid        int
+	haveGID    bool
+	namespaces []string // e.g. "user", "mount", "pid" - see applyPlatformSandbox
+	cpuSeconds int
+	asBytes    int64
+	nofile     int
+	fsizeBytes int64
+	timeout    time.Duration
+}
+
+func loadSandboxConfig() sandboxConfig {
+	var cfg sandboxConfig
+
+	cfg.chroot = os.Getenv("SANDBOX_CHROOT")
+
+	if v := os.Getenv("SANDBOX_UID"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil {
+			cfg.uid, cfg.haveUID = n, true
+		}
+	}
+	if v := os.Getenv("SANDBOX_GID"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil {
+			cfg.gid, cfg.haveGID = n, true
+		}
+	}
+	if v := os.Getenv("SANDBOX_NAMESPACES"); v != "" {
+		for _, ns := range strings.Split(v, ",") {
+			if ns = strings.TrimSpace(ns); ns != "" {
+				cfg.namespaces = append(cfg.namespaces, ns)
+			}
+		}
+	}
+
+	cfg.cpuSeconds = intEnv("SANDBOX_CPU_SECONDS")
+	cfg.asBytes = int64Env("SANDBOX_AS_BYTES")
+	cfg.nofile = intEnv("SANDBOX_NOFILE")
+	cfg.fsizeBytes = int64Env("SANDBOX_FSIZE_BYTES")
+
+	if n := intEnv("SANDBOX_TIMEOUT_SECONDS"); n > 0 {
+		cfg.timeout = time.Duration(n) * time.Second
+	}
+
+	return cfg
+}
+
+func intEnv(name string) int {
+	if v := os.Getenv(name); v != "" {
+		if n, err := strconv.Atoi(v); err == nil {
+			return n
+		}
+	}
+	return 0
+}
+
+func int64Env(name string) int64 {
+	if v := os.Getenv(name); v != "" {
+		if n, err := strconv.ParseInt(v, 10, 64); err == nil {
+			return n
+		}
+	}
+	return 0
+}
+
+func (cfg sandboxConfig) enabled() bool {
+	return cfg.chroot != "" || cfg.haveUID || cfg.haveGID || len(cfg.namespaces) > 0 ||
+		cfg.cpuSeconds > 0 || cfg.asBytes > 0 || cfg.nofile > 0 || cfg.fsizeBytes > 0 || cfg.timeout > 0
+}
+
+// prepareSandbox applies cfg's process isolation (pgid/chroot/uid-gid/
+// namespaces) to cmd via SysProcAttr, and wraps it in a shell that applies
+// the configured rlimits before exec'ing the real command. It must be
+// called before cmd.Start.
+func prepareSandbox(cmd *exec.Cmd, cfg sandboxConfig) (*exec.Cmd, error) {
+	if !cfg.enabled() {
+		return cmd, nil
+	}
+
+	attr := cmd.SysProcAttr
+	if attr == nil {
+		attr = &syscall.SysProcAttr{}
+	}
+	attr.Setpgid = true
+	if cfg.chroot != "" {
+		attr.Chroot = cfg.chroot
+	}
+	if err := applyPlatformSandbox(attr, cfg); err != nil {
+		return nil, err
+	}
+	cmd.SysProcAttr = attr
+
+	return wrapWithRlimits(cmd, cfg), nil
+}
+
+// wrapWithRlimits re-execs cmd under "sh -c" with `ulimit` applied first,
+// since Go's os/exec has no hook to run code between fork and exec.
+func wrapWithRlimits(cmd *exec.Cmd, cfg sandboxConfig) *exec.Cmd {
+	var ulimits []string
+	if cfg.cpuSeconds > 0 {
+		ulimits = append(ulimits, fmt.Sprintf("ulimit -t %d", cfg.cpuSeconds))
+	}
+	if cfg.asBytes > 0 {
+		ulimits = append(ulimits, fmt.Sprintf("ulimit -v %d", cfg.asBytes/1024)) // -v is in KiB
+	}
+	if cfg.nofile > 0 {
+		ulimits = append(ulimits, fmt.Sprintf("ulimit -n %d", cfg.nofile))
+	}
+	if cfg.fsizeBytes > 0 {
+		ulimits = append(ulimits, fmt.Sprintf("ulimit -f %d", cfg.fsizeBytes/512)) // -f is in 512B blocks
+	}
+	if len(ulimits) == 0 {
+		return cmd
+	}
+	ulimits = append(ulimits, `exec "$0" "$@"`)
+
+	wrapped := exec.Command("sh", append([]string{"-c", strings.Join(ulimits, " && "), cmd.Path}, cmd.Args[1:]...)...)
+	wrapped.Dir = cmd.Dir
+	wrapped.Env = cmd.Env
+	wrapped.SysProcAttr = cmd.SysProcAttr
+	wrapped.Stdin = cmd.Stdin
+	wrapped.Stdout = cmd.Stdout
+	wrapped.Stderr = cmd.Stderr
+	return wrapped
+}
+
+// armTimeout kills cmd's whole process group if it's still running after
+// timeout. Call after cmd.Start(); the returned cancel func must be called
+// once the command has finished to disarm the timer.
+func armTimeout(cmd *exec.Cmd, timeout time.Duration) (cancel func()) {
+	if timeout <= 0 || cmd.Process == nil {
+		return func() {}
+	}
+
+	timer := time.AfterFunc(timeout, func() {
+		// negative pid targets the whole process group set up via Setpgid
+		syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	})
+	return func() { timer.Stop() }
+}
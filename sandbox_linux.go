@@ -0,0 +1,43 @@
+//go:build linux
+
+package main
+
+import (
+	"fmt"
+	"syscall"
+)
+
+// namespaceFlags maps the SANDBOX_NAMESPACES names to their clone(2) flags.
+var namespaceFlags = map[string]uintptr{
+	"user":  syscall.CLONE_NEWUSER,
+	"mount": syscall.CLONE_NEWNS,
+	"pid":   syscall.CLONE_NEWPID,
+	"net":   syscall.CLONE_NEWNET,
+	"uts":   syscall.CLONE_NEWUTS,
+	"ipc":   syscall.CLONE_NEWIPC,
+}
+
+// applyPlatformSandbox drops privileges to the configured uid/gid and
+// requests the configured Linux namespaces via clone flags.
+func applyPlatformSandbox(attr *syscall.SysProcAttr, cfg sandboxConfig) error {
+	if cfg.haveUID || cfg.haveGID {
+		cred := &syscall.Credential{}
+		if cfg.haveUID {
+			cred.Uid = uint32(cfg.uid)
+		}
+		if cfg.haveGID {
+			cred.Gid = uint32(cfg.gid)
+		}
+		attr.Credential = cred
+	}
+
+	for _, ns := range cfg.namespaces {
+		flag, ok := namespaceFlags[ns]
+		if !ok {
+			return fmt.Errorf("unknown sandbox namespace %q", ns)
+		}
+		attr.Cloneflags |= flag
+	}
+
+	return nil
+}
@@ -0,0 +1,29 @@
+//go:build !linux
+
+package main
+
+import (
+	"fmt"
+	"syscall"
+)
+
+// applyPlatformSandbox only supports uid/gid dropping outside Linux;
+// namespace isolation is Linux-only (requires clone(2) namespace flags).
+func applyPlatformSandbox(attr *syscall.SysProcAttr, cfg sandboxConfig) error {
+	if len(cfg.namespaces) > 0 {
+		return fmt.Errorf("sandbox namespaces are only supported on linux")
+	}
+
+	if cfg.haveUID || cfg.haveGID {
+		cred := &syscall.Credential{}
+		if cfg.haveUID {
+			cred.Uid = uint32(cfg.uid)
+		}
+		if cfg.haveGID {
+			cred.Gid = uint32(cfg.gid)
+		}
+		attr.Credential = cred
+	}
+
+	return nil
+}
@@ -10,7 +10,6 @@ import (
 	"net/http"
 	"os"
 	"os/exec"
-	p "path"
 	"strconv"
 	"strings"
 	"time"
@@ -18,40 +17,37 @@ import (
 	"golang.org/x/net/websocket"
 )
 
-var authUser, authPassword string
-var requireBasicAuth bool
-
-func init() {
-	basicAuth := os.Getenv("BASIC_AUTH")
-	if basicAuth != "" {
-		requireBasicAuth = true
-		bits := strings.SplitN(basicAuth, ":", 2)
-		authUser = bits[0]
-		if len(bits) == 2 {
-			authPassword = bits[1]
-		}
-	}
-}
-
 func main() {
 	http.HandleFunc("/>/exit", authorize(handleExit))
+	http.HandleFunc("/>/jobs", authorize(handleJobs))
+	http.HandleFunc("/>/jobs/", authorize(handleJob))
 	http.HandleFunc("/", authorize(handleAny))
 	log.Fatal(http.ListenAndServe(":"+httpPort(), nil))
 }
 
 func authorize(handler func(http.ResponseWriter, *http.Request)) func(res http.ResponseWriter, req *http.Request) {
 	return func(res http.ResponseWriter, req *http.Request) {
-		if requireBasicAuth {
-			if user, pass, ok := req.BasicAuth(); !ok {
+		user := ""
+		if authRequired() {
+			var ok bool
+			if user, ok = authenticate(req); !ok {
+				res.Header().Add("WWW-Authenticate", `Basic realm="shaas"`)
+				res.Header().Add("WWW-Authenticate", "Bearer")
 				handleError(res, req, fmt.Errorf("Authorization Required"), http.StatusUnauthorized, "Not Authorized")
 				return
-			} else if user != authUser || pass != authPassword {
-				handleError(res, req, fmt.Errorf("Not Authorized"), http.StatusUnauthorized, "Not Authorized")
-				return
 			}
 		}
 
-		handler(res, req)
+		method := req.Method
+		if isWebSocketRequest(req) {
+			method = "WS"
+		}
+		if !authorizeACL(req.URL.Path, method, user) {
+			handleError(res, req, fmt.Errorf("Forbidden"), http.StatusForbidden, "Not Authorized")
+			return
+		}
+
+		handler(res, withUser(req, user))
 	}
 }
 
@@ -91,7 +87,7 @@ func handleAny(res http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	if strings.HasPrefix(req.Header.Get("Origin"), "ws://") { // TODO: how to detect? scheme is null
+	if isWebSocketRequest(req) {
 		handleWs(res, req, path, pathInfo)
 		return
 	}
@@ -108,6 +104,11 @@ func handleAny(res http.ResponseWriter, req *http.Request) {
 
 func handleGet(res http.ResponseWriter, req *http.Request, path *os.File, pathInfo os.FileInfo) {
 	if pathInfo.Mode().IsDir() {
+		if format := archiveFormatForGet(req); format != "" {
+			serveArchive(res, req, path.Name(), format)
+			return
+		}
+
 		fileInfos, err := ioutil.ReadDir(path.Name())
 		if err != nil {
 			handleError(res, req, err, http.StatusInternalServerError, "Error reading directory")
@@ -141,6 +142,23 @@ func handleGet(res http.ResponseWriter, req *http.Request, path *os.File, pathIn
 }
 
 func handlePost(res http.ResponseWriter, req *http.Request, path *os.File, pathInfo os.FileInfo) {
+	if pathInfo.Mode().IsDir() {
+		if format := archiveFormatForContentType(req.Header.Get("Content-Type")); format != "" {
+			extractArchive(res, req, path.Name(), format)
+			return
+		}
+	}
+
+	if isFastCGIRequest(req) {
+		handleFastCGI(res, req, fastCGIBackend)
+		return
+	}
+
+	if isCGIRequest(req) {
+		handleCGI(res, req, path, pathInfo)
+		return
+	}
+
 	resFlusherWriter := flushWriterWrapper{res.(flushWriter)}
 	execCmd(res, req, path, pathInfo, req.Body, resFlusherWriter, false)
 }
@@ -153,43 +171,52 @@ func handleWs(res http.ResponseWriter, req *http.Request, path *os.File, pathInf
 	websocket.Handler(handler).ServeHTTP(res, req)
 }
 
-func execCmd(res http.ResponseWriter, req *http.Request, path *os.File, pathInfo os.FileInfo, in io.Reader, out io.Writer, interactive bool) {
-	var cmd *exec.Cmd
-
+// newCmdForPath builds the command used to run path: "bash" for a directory,
+// or the file itself if it's a regular executable.
+func newCmdForPath(path *os.File, pathInfo os.FileInfo) (*exec.Cmd, error) {
 	if pathInfo.Mode().IsDir() {
-		if interactive {
-			// TODO: allow interactive session to have a prompt, support heredocs, handle arrow keys, and generally act like a real terminal
-			// cmd = exec.Command("bash", "-i") // double echos (ws + bash -i) and displays arrow character
-
-			// pseudo-interactive-bash worksaround `bash -i` echoing problem, but breaks on heredocs and probably other bash special cases
-			dir, err := os.Getwd()
-			if err != nil {
-				log.Fatal(err)
-			}
-			log.Println(dir)
-			cmd = exec.Command(p.Join(dir, "bin", "pseudo-interactive-bash"))
-		} else {
-			cmd = exec.Command("bash")
-		}
+		cmd := exec.Command("bash")
 		cmd.Dir = path.Name()
+		return cmd, nil
 	} else if pathInfo.Mode().IsRegular() && pathInfo.Mode()&0110 != 0 /* is executable for user or group */ {
-		cmd = exec.Command(path.Name())
+		cmd := exec.Command(path.Name())
 		cmd.Dir = path.Name()[0:strings.LastIndex(path.Name(), string(os.PathSeparator))]
-	} else {
-		handleError(res, req, nil,
+		return cmd, nil
+	}
+	return nil, fmt.Errorf("Invalid file type. Only directories and regular executable file are supported")
+}
+
+func execCmd(res http.ResponseWriter, req *http.Request, path *os.File, pathInfo os.FileInfo, in io.Reader, out io.Writer, interactive bool) {
+	cmd, err := newCmdForPath(path, pathInfo)
+	if err != nil {
+		handleError(res, req, err,
 			http.StatusBadRequest,
 			"Invalid file type for POST. Only directories and regular executable file are supported")
 		return
 	}
 
 	cmd.Env = append(os.Environ(), cgiEnv(req)...)
+
+	sandboxCfg := loadSandboxConfig()
+	if cmd, err = prepareSandbox(cmd, sandboxCfg); err != nil {
+		handleError(res, req, err, http.StatusInternalServerError, "Error applying sandbox")
+		return
+	}
+
 	if interactive {
-		cmd.Env = append(cmd.Env, "PS1=\\[\\033[01;34m\\]\\w\\[\\033[00m\\] \\[\\033[01;32m\\]$ \\[\\033[00m\\]")
+		cmd.Env = append(cmd.Env, "TERM=xterm", "PS1=\\[\\033[01;34m\\]\\w\\[\\033[00m\\] \\[\\033[01;32m\\]$ \\[\\033[00m\\]")
+		err = runPTY(cmd, in, out, func() func() { return armTimeout(cmd, sandboxCfg.timeout) })
+	} else {
+		cmd.Stdin = in
+		cmd.Stdout = out
+		cmd.Stderr = out
+		if err = cmd.Start(); err == nil {
+			cancel := armTimeout(cmd, sandboxCfg.timeout)
+			err = cmd.Wait()
+			cancel()
+		}
 	}
-	cmd.Stdin = in
-	cmd.Stdout = out
-	cmd.Stderr = out
-	if err := cmd.Run(); err != nil {
+	if err != nil {
 		// error already sent to client. log only
 		log.Printf("method=%s path=%q message=%q", req.Method, req.URL.Path, err)
 	}